@@ -0,0 +1,1200 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/salt"
+)
+
+const (
+	// tokenSubPath is the sub-path used for the token store
+	// view. This is nested under the system view.
+	tokenSubPath = "token/"
+
+	// tokenIDPrefix is the prefix used to store the entries
+	// for token IDs, keyed by their salted ID.
+	tokenIDPrefix = "id/"
+
+	// tokenParentPrefix is the prefix used to store the
+	// parent -> child index, used to revoke trees of tokens.
+	tokenParentPrefix = "parent/"
+
+	// tokenAccessorPrefix is the prefix used to store the
+	// accessor -> salted ID index, used by the accessor
+	// endpoints to operate on a token without ever handling
+	// the token value itself.
+	tokenAccessorPrefix = "accessor/"
+
+	// tokenRolePrefix is the prefix used to store named token
+	// roles, keyed directly by role name.
+	tokenRolePrefix = "role/"
+
+	// rootPolicyName is the policy name given to root tokens
+	rootPolicyName = "root"
+)
+
+// TokenEntry is used to represent a given token
+type TokenEntry struct {
+	// ID of this entry, generally a random UUID
+	ID string
+
+	// Accessor for this token, a random UUID that can be used
+	// to operate on the token without knowledge of the ID
+	Accessor string
+
+	// Parent token, used for revocation trees
+	Parent string
+
+	// Which named policies are applicable
+	Policies []string
+
+	// Used for audit trails, this is something like "auth/user/login"
+	Path string
+
+	// Used for auditing. This could include things like "source", "user",
+	// "ip" and any other metadata that the auth method wants to store
+	Meta map[string]string
+
+	// Used for operators to be able to associate with the
+	// source that the token was created from
+	DisplayName string
+
+	// The time at which this entry was created
+	CreationTime int64
+
+	// Role is the name of the token role used to create this token,
+	// if any
+	Role string
+
+	// Period, if set, makes this a periodic token. Renewals always
+	// reset the lease to this duration rather than extending it,
+	// and the max TTL is ignored for as long as the token is renewed
+	// on time.
+	Period time.Duration
+
+	// ExplicitMaxTTL, if set, is a hard cap on the token's lifetime
+	// that cannot be extended by renewal, independent of any system
+	// or mount-tuned max TTL.
+	ExplicitMaxTTL time.Duration
+
+	// NumUses, if greater than zero, limits the number of requests
+	// this token may authenticate before it is automatically revoked.
+	// Zero means unlimited use.
+	NumUses int
+
+	// BoundCIDRs, if set, restricts use of this token to requests
+	// arriving from one of the given CIDR blocks.
+	BoundCIDRs []string
+}
+
+// tokenAccessorEntry is the value stored under the salted-accessor key in
+// the accessor index. It keeps the plaintext accessor alongside the
+// salted token ID so that list-accessors can return handles that are
+// themselves valid input to lookup-accessor/revoke-accessor, rather than
+// the already-salted key the index is stored under.
+type tokenAccessorEntry struct {
+	Accessor string
+	SaltedID string
+}
+
+// TokenRole is used to represent a named role, which carries a set of
+// fixed properties that are stamped onto every token created against
+// it. Roles let a non-root caller mint tokens with a shape (policies,
+// periodic lease, orphan status) that plain auth/token/create will
+// not allow them to pick for themselves.
+type TokenRole struct {
+	// Name of the role
+	Name string
+
+	// AllowedPolicies, if set, is the list of policies that tokens
+	// created against this role may be granted. It is enforced
+	// instead of the usual "subset of caller's policies" rule.
+	AllowedPolicies []string
+
+	// DisallowedPolicies is a list of policies that may never be
+	// granted to a token created against this role, even if the
+	// caller or AllowedPolicies would otherwise permit it.
+	DisallowedPolicies []string
+
+	// Orphan, if true, tokens created against this role have no
+	// parent and are not revoked when their creator is.
+	Orphan bool
+
+	// Period, if set, marks tokens created against this role as
+	// periodic with the given renewal period.
+	Period time.Duration
+
+	// ExplicitMaxTTL, if set, is stamped onto created tokens as a
+	// hard cap on their lifetime.
+	ExplicitMaxTTL time.Duration
+
+	// PathSuffix, if set, is appended to the audit path of tokens
+	// created against this role, so that requests made with them
+	// can be tied back to the role in audit logs.
+	PathSuffix string
+}
+
+// TokenStore is used to manage client tokens. Tokens are used for
+// clients to authenticate, and each token is mapped to an applicable
+// set of policy which is used for authorization.
+type TokenStore struct {
+	view *BarrierView
+	salt *salt.Salt
+
+	// expiration is used to renew and revoke the leases associated
+	// with outstanding tokens. It is wired up after construction via
+	// SetExpirationManager, mirroring how the core assembles the
+	// other logical backends.
+	expiration *ExpirationManager
+
+	// policy is used by the capabilities-self endpoint to resolve a
+	// caller's policies into the set of capabilities they are granted
+	// on a given path.
+	policy *PolicyStore
+}
+
+// NewTokenStore is used to construct a token store that is
+// backed by the given barrier view.
+func NewTokenStore(c *Core) (*TokenStore, error) {
+	view := c.systemBarrierView.SubView(tokenSubPath)
+
+	salt, err := salt.NewSalt(view, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup salt: %v", err)
+	}
+
+	t := &TokenStore{
+		view:   view,
+		salt:   salt,
+		policy: c.policyStore,
+	}
+	return t, nil
+}
+
+// SaltID is used to apply a salt and hash to a token to make sure
+// the value is not deterministic from the token id.
+func (ts *TokenStore) SaltID(id string) string {
+	return ts.salt.SaltID(id)
+}
+
+// SetExpirationManager is used to provide the token store with an
+// expiration manager. This is used to renew and revoke the leases
+// associated with tokens.
+func (ts *TokenStore) SetExpirationManager(exp *ExpirationManager) {
+	ts.expiration = exp
+}
+
+// RootToken is used to generate a new token with root privileges and no parent
+func (ts *TokenStore) RootToken() (*TokenEntry, error) {
+	te := &TokenEntry{
+		Policies:     []string{rootPolicyName},
+		Path:         "sys/root",
+		CreationTime: time.Now().Unix(),
+	}
+	if err := ts.Create(te); err != nil {
+		return nil, err
+	}
+	return te, nil
+}
+
+// Create is used to create a new token entry. The entry is assigned
+// a newly generated ID if not provided, along with a fresh accessor
+// used for out-of-band lookups and revocation.
+func (ts *TokenStore) Create(entry *TokenEntry) error {
+	if entry.ID == "" {
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+	}
+	entry.Accessor = ""
+	accessor, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	entry.Accessor = accessor
+
+	saltedID := ts.SaltID(entry.ID)
+	saltedAccessor := ts.SaltID(entry.Accessor)
+
+	if err := ts.storeEntry(entry); err != nil {
+		return err
+	}
+
+	// Store the accessor -> salted ID index so callers can look up
+	// and revoke tokens without ever persisting the token value. The
+	// plaintext accessor is kept in the value (not the key, which is
+	// salted) so that list-accessors can hand back something a caller
+	// can feed straight back into lookup-accessor/revoke-accessor.
+	aeValue, err := json.Marshal(&tokenAccessorEntry{Accessor: entry.Accessor, SaltedID: saltedID})
+	if err != nil {
+		return fmt.Errorf("failed to encode accessor index entry: %v", err)
+	}
+	ae := &logical.StorageEntry{Key: tokenAccessorPrefix + saltedAccessor, Value: aeValue}
+	if err := ts.view.Put(ae); err != nil {
+		return fmt.Errorf("failed to persist accessor index entry: %v", err)
+	}
+
+	if entry.Parent != "" {
+		path := tokenParentPrefix + ts.SaltID(entry.Parent) + "/" + saltedID
+		pe := &logical.StorageEntry{Key: path, Value: []byte(entry.ID)}
+		if err := ts.view.Put(pe); err != nil {
+			return fmt.Errorf("failed to persist parent entry: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// storeEntry persists an entry's current field values under its existing
+// ID and accessor, without touching the accessor or parent indexes. It is
+// used both by Create and by in-place updates such as the use-count
+// decrement performed by UseToken.
+func (ts *TokenStore) storeEntry(entry *TokenEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode entry: %v", err)
+	}
+
+	le := &logical.StorageEntry{Key: tokenIDPrefix + ts.SaltID(entry.ID), Value: raw}
+	if err := ts.view.Put(le); err != nil {
+		return fmt.Errorf("failed to persist entry: %v", err)
+	}
+	return nil
+}
+
+// Lookup is used to find a token given its ID
+func (ts *TokenStore) Lookup(id string) (*TokenEntry, error) {
+	if id == "" {
+		return nil, fmt.Errorf("cannot lookup blank token")
+	}
+	return ts.lookupSalted(ts.SaltID(id))
+}
+
+// lookupSalted is used to find a token given its salted ID
+func (ts *TokenStore) lookupSalted(saltedID string) (*TokenEntry, error) {
+	raw, err := ts.view.Get(tokenIDPrefix + saltedID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry: %v", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	entry := new(TokenEntry)
+	if err := json.Unmarshal(raw.Value, entry); err != nil {
+		return nil, fmt.Errorf("failed to decode entry: %v", err)
+	}
+	return entry, nil
+}
+
+// lookupByAccessor is used to find a token's salted ID given its accessor
+func (ts *TokenStore) lookupByAccessor(accessor string) (string, error) {
+	raw, err := ts.view.Get(tokenAccessorPrefix + ts.SaltID(accessor))
+	if err != nil {
+		return "", fmt.Errorf("failed to read accessor entry: %v", err)
+	}
+	if raw == nil {
+		return "", fmt.Errorf("invalid accessor")
+	}
+
+	var ae tokenAccessorEntry
+	if err := json.Unmarshal(raw.Value, &ae); err != nil {
+		return "", fmt.Errorf("failed to decode accessor entry: %v", err)
+	}
+	return ae.SaltedID, nil
+}
+
+// lookupByAccessorEntry is used to find a token entry given its accessor
+func (ts *TokenStore) lookupByAccessorEntry(accessor string) (*TokenEntry, error) {
+	saltedID, err := ts.lookupByAccessor(accessor)
+	if err != nil {
+		return nil, err
+	}
+	return ts.lookupSalted(saltedID)
+}
+
+// UseToken enforces the CIDR binding and use-count limit carried on a
+// token entry: it rejects requests outside BoundCIDRs, and for tokens
+// with a limited NumUses consumes one use, revoking the token the
+// moment it reaches zero. It is invoked on every path in this store
+// where req.ClientToken is itself the token being exercised -
+// lookup-self, renew-self and revoke-self (via useClientToken), and
+// capabilities-self and create/create-against-role (directly, where in
+// the create case it gates the parent). Paths
+// that name some other token in the request path instead (lookup/<id>,
+// revoke/<id>, renew/<id>, ...) are operator actions authorized
+// upstream by ACL policy rather than uses of that token, so they leave
+// it untouched.
+func (ts *TokenStore) UseToken(req *logical.Request, te *TokenEntry) error {
+	if te == nil {
+		return nil
+	}
+
+	if err := ts.checkBoundCIDRs(te, req); err != nil {
+		return err
+	}
+
+	if te.NumUses <= 0 {
+		return nil
+	}
+
+	te.NumUses--
+	if te.NumUses == 0 {
+		return ts.Revoke(te.ID)
+	}
+	return ts.storeEntry(te)
+}
+
+// useClientToken looks up the token authenticating req and runs it
+// through UseToken, so lookup-self, renew-self and revoke-self are
+// charged against the caller's own NumUses/BoundCIDRs. A request with no ClientToken
+// yet (nothing to authenticate with) is left alone.
+func (ts *TokenStore) useClientToken(req *logical.Request) error {
+	if req.ClientToken == "" {
+		return nil
+	}
+	te, err := ts.Lookup(req.ClientToken)
+	if err != nil {
+		return fmt.Errorf("failed to lookup: %v", err)
+	}
+	return ts.UseToken(req, te)
+}
+
+// checkBoundCIDRs enforces that a token bound to a set of CIDRs is only
+// used from a request whose remote address falls within one of them.
+func (ts *TokenStore) checkBoundCIDRs(te *TokenEntry, req *logical.Request) error {
+	if len(te.BoundCIDRs) == 0 {
+		return nil
+	}
+
+	var remoteAddr string
+	if req != nil && req.Connection != nil {
+		remoteAddr = req.Connection.RemoteAddr
+	}
+	if !remoteAddrInCIDRs(remoteAddr, te.BoundCIDRs) {
+		return logical.ErrPermissionDenied
+	}
+	return nil
+}
+
+// remoteAddrInCIDRs reports whether remoteAddr (optionally including a
+// port) falls within any of the given CIDR blocks.
+func remoteAddrInCIDRs(remoteAddr string, cidrs []string) bool {
+	if remoteAddr == "" {
+		return false
+	}
+
+	ip := net.ParseIP(remoteAddr)
+	if ip == nil {
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoke is used to invalidate a given token, any child tokens
+// will be orphaned.
+func (ts *TokenStore) Revoke(id string) error {
+	if id == "" {
+		return fmt.Errorf("cannot revoke blank token")
+	}
+	return ts.revokeSalted(ts.SaltID(id))
+}
+
+// revokeSalted is used to invalidate a given salted token,
+// any child tokens will be orphaned.
+func (ts *TokenStore) revokeSalted(saltedID string) error {
+	entry, err := ts.lookupSalted(saltedID)
+	if err != nil {
+		return err
+	}
+
+	if entry != nil && entry.Accessor != "" {
+		if err := ts.view.Delete(tokenAccessorPrefix + ts.SaltID(entry.Accessor)); err != nil {
+			return fmt.Errorf("failed to delete accessor entry: %v", err)
+		}
+	}
+
+	if err := ts.view.Delete(tokenIDPrefix + saltedID); err != nil {
+		return fmt.Errorf("failed to delete entry: %v", err)
+	}
+
+	if entry != nil && entry.Parent != "" {
+		path := tokenParentPrefix + ts.SaltID(entry.Parent) + "/" + saltedID
+		if err := ts.view.Delete(path); err != nil {
+			return fmt.Errorf("failed to delete entry: %v", err)
+		}
+	}
+
+	if entry != nil {
+		path := tokenParentPrefix + saltedID + "/"
+		children, err := ts.view.List(path)
+		if err != nil {
+			return fmt.Errorf("failed to scan for children: %v", err)
+		}
+		for _, child := range children {
+			if err := ts.view.Delete(path + child); err != nil {
+				return fmt.Errorf("failed to delete child index entry: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RevokeTree is used to invalidate a given token and all
+// child tokens.
+func (ts *TokenStore) RevokeTree(id string) error {
+	if id == "" {
+		return fmt.Errorf("cannot revoke blank token")
+	}
+	return ts.revokeTreeSalted(ts.SaltID(id))
+}
+
+// revokeTreeSalted is used to invalidate a given token and all
+// child tokens using the salted ID.
+func (ts *TokenStore) revokeTreeSalted(saltedID string) error {
+	path := tokenParentPrefix + saltedID + "/"
+	children, err := ts.view.List(path)
+	if err != nil {
+		return fmt.Errorf("failed to scan for children: %v", err)
+	}
+	for _, child := range children {
+		if err := ts.revokeTreeSalted(child); err != nil {
+			return err
+		}
+	}
+
+	if err := ts.revokeSalted(saltedID); err != nil {
+		return fmt.Errorf("failed to revoke entry: %v", err)
+	}
+	return nil
+}
+
+// RevokeAll is used to invalidate all tokens
+func (ts *TokenStore) RevokeAll() error {
+	saltedIDs, err := ts.view.List(tokenIDPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to scan tokens: %v", err)
+	}
+	for _, saltedID := range saltedIDs {
+		if err := ts.revokeSalted(saltedID); err != nil {
+			return fmt.Errorf("failed to revoke '%s': %v", saltedID, err)
+		}
+	}
+	return nil
+}
+
+// HandleRequest is used to handle a request and generate a response.
+// The backend is hand-routed here instead of relying on the generic
+// framework router, as the token store predates it.
+func (ts *TokenStore) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	switch {
+	case req.Path == "create":
+		return ts.handleCreate(req)
+	case strings.HasPrefix(req.Path, "create/"):
+		return ts.handleCreateAgainstRole(req, strings.TrimPrefix(req.Path, "create/"))
+	case req.Path == "roles":
+		return ts.handleRoleList(req)
+	case strings.HasPrefix(req.Path, "roles/"):
+		return ts.handleRole(req, strings.TrimPrefix(req.Path, "roles/"))
+	case req.Path == "lookup-self":
+		return ts.handleLookupSelf(req)
+	case req.Path == "renew-self":
+		return ts.handleRenewSelf(req)
+	case req.Path == "revoke-self":
+		return ts.handleRevokeSelf(req)
+	case req.Path == "capabilities-self":
+		return ts.handleCapabilitiesSelf(req)
+	case strings.HasPrefix(req.Path, "lookup-accessor/"):
+		return ts.handleLookupAccessor(req, strings.TrimPrefix(req.Path, "lookup-accessor/"))
+	case strings.HasPrefix(req.Path, "lookup/"):
+		return ts.handleLookup(req, strings.TrimPrefix(req.Path, "lookup/"))
+	case strings.HasPrefix(req.Path, "revoke-accessor/"):
+		return ts.handleRevokeAccessor(req, strings.TrimPrefix(req.Path, "revoke-accessor/"))
+	case strings.HasPrefix(req.Path, "revoke-orphan/"):
+		return ts.handleRevokeOrphan(req, strings.TrimPrefix(req.Path, "revoke-orphan/"))
+	case strings.HasPrefix(req.Path, "revoke-prefix/"):
+		return ts.handleRevokePrefix(req, strings.TrimPrefix(req.Path, "revoke-prefix/"))
+	case strings.HasPrefix(req.Path, "revoke/"):
+		return ts.handleRevokeTree(req, strings.TrimPrefix(req.Path, "revoke/"))
+	case strings.HasPrefix(req.Path, "renew/"):
+		return ts.handleRenew(req, strings.TrimPrefix(req.Path, "renew/"))
+	case req.Path == "list-accessors":
+		return ts.handleListAccessors(req)
+	default:
+		return nil, logical.ErrUnsupportedPath
+	}
+}
+
+// handleCreate handles the auth/token/create path for creation of new tokens
+func (ts *TokenStore) handleCreate(req *logical.Request) (*logical.Response, error) {
+	return ts.handleCreateCommon(req, nil)
+}
+
+// handleCreateAgainstRole handles the auth/token/create/role path, creating
+// a token whose shape is constrained by the named role rather than by the
+// default "subset of caller's policies" rule.
+func (ts *TokenStore) handleCreateAgainstRole(req *logical.Request, name string) (*logical.Response, error) {
+	role, err := ts.tokenRole(name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role %q", name)), logical.ErrInvalidRequest
+	}
+	return ts.handleCreateCommon(req, role)
+}
+
+// handleCreateCommon contains the shared logic for auth/token/create and
+// auth/token/create/role. When role is nil, the normal root/subset-of-parent
+// policy rules apply; when set, the role's constraints take over.
+func (ts *TokenStore) handleCreateCommon(req *logical.Request, role *TokenRole) (*logical.Response, error) {
+	parent, err := ts.Lookup(req.ClientToken)
+	if err != nil || parent == nil {
+		return logical.ErrorResponse("parent token lookup failed"), logical.ErrInvalidRequest
+	}
+	if err := ts.UseToken(req, parent); err != nil {
+		return nil, err
+	}
+	isRoot := strContains(parent.Policies, rootPolicyName)
+
+	path := "auth/token/create"
+	if role != nil {
+		path = "auth/token/create/" + role.Name
+		if role.PathSuffix != "" {
+			path = path + "/" + role.PathSuffix
+		}
+	}
+
+	te := &TokenEntry{
+		Parent:       req.ClientToken,
+		Path:         path,
+		Meta:         getStringMapData(req.Data, "meta"),
+		DisplayName:  "token",
+		CreationTime: time.Now().Unix(),
+	}
+
+	if role != nil {
+		te.Role = role.Name
+		te.Period = role.Period
+		te.ExplicitMaxTTL = role.ExplicitMaxTTL
+		if role.Orphan {
+			te.Parent = ""
+		}
+	}
+
+	tokenPolicies := getStringSliceData(req.Data, "policies")
+	if len(tokenPolicies) == 0 && role != nil && len(role.AllowedPolicies) > 0 {
+		tokenPolicies = role.AllowedPolicies
+	}
+
+	if numUsesRaw, ok := req.Data["num_uses"]; ok {
+		n, err := parseIntData(numUsesRaw)
+		if err != nil {
+			return logical.ErrorResponse("invalid num_uses"), logical.ErrInvalidRequest
+		}
+		te.NumUses = n
+	}
+	te.BoundCIDRs = getStringSliceData(req.Data, "bound_cidrs")
+
+	if noParentRaw, ok := req.Data["no_parent"]; ok {
+		noParent, _ := noParentRaw.(bool)
+		if noParent {
+			if !isRoot {
+				return logical.ErrorResponse("root required to create orphan token"), logical.ErrInvalidRequest
+			}
+			te.Parent = ""
+		}
+	}
+
+	if idRaw, ok := req.Data["id"]; ok {
+		if !isRoot {
+			return logical.ErrorResponse("root required to specify token id"), logical.ErrInvalidRequest
+		}
+		te.ID, _ = idRaw.(string)
+	}
+
+	if len(tokenPolicies) == 0 {
+		return logical.ErrorResponse("token must have at least one policy"), logical.ErrInvalidRequest
+	}
+
+	if !isRoot && strContains(tokenPolicies, rootPolicyName) {
+		return logical.ErrorResponse("root policy cannot be granted by a non-root token"), logical.ErrInvalidRequest
+	}
+
+	if role != nil {
+		if len(role.AllowedPolicies) > 0 {
+			// The role names an explicit superset the caller may draw
+			// from, which is allowed to exceed the caller's own policies.
+			if !strListSubset(role.AllowedPolicies, tokenPolicies) {
+				return logical.ErrorResponse("token policies must be subset of the role's allowed policies"), logical.ErrInvalidRequest
+			}
+		} else if !isRoot && !strListSubset(parent.Policies, tokenPolicies) {
+			// No allowed_policies means the role grants no escalation of
+			// its own; fall back to the same subset-of-caller rule as a
+			// plain create.
+			return logical.ErrorResponse("child policies must be subset of parent"), logical.ErrInvalidRequest
+		}
+		if strListOverlap(role.DisallowedPolicies, tokenPolicies) {
+			return logical.ErrorResponse("token policies must not contain any of the role's disallowed policies"), logical.ErrInvalidRequest
+		}
+	} else if !isRoot && !strListSubset(parent.Policies, tokenPolicies) {
+		return logical.ErrorResponse("child policies must be subset of parent"), logical.ErrInvalidRequest
+	}
+	te.Policies = tokenPolicies
+
+	if err := ts.Create(te); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to create token: %v", err)), logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Auth: &logical.Auth{
+			ClientToken: te.ID,
+			Accessor:    te.Accessor,
+			Policies:    te.Policies,
+		},
+	}
+
+	if leaseRaw, ok := req.Data["lease"]; ok {
+		leaseStr, _ := leaseRaw.(string)
+		dur, err := time.ParseDuration(leaseStr)
+		if err != nil {
+			return logical.ErrorResponse("invalid lease"), logical.ErrInvalidRequest
+		}
+		resp.Auth.Lease = dur
+		resp.Auth.Renewable = true
+	}
+
+	return resp, nil
+}
+
+// tokenRole loads a named token role from storage, returning nil if it
+// does not exist.
+func (ts *TokenStore) tokenRole(name string) (*TokenRole, error) {
+	raw, err := ts.view.Get(tokenRolePrefix + name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role: %v", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	role := new(TokenRole)
+	if err := json.Unmarshal(raw.Value, role); err != nil {
+		return nil, fmt.Errorf("failed to decode role: %v", err)
+	}
+	return role, nil
+}
+
+// handleRole dispatches auth/token/roles/name by operation, mirroring the
+// CRUD conventions used by other named-config backends.
+func (ts *TokenStore) handleRole(req *logical.Request, name string) (*logical.Response, error) {
+	if name == "" {
+		return logical.ErrorResponse("role name must be set"), logical.ErrInvalidRequest
+	}
+	switch req.Operation {
+	case logical.ReadOperation:
+		return ts.handleRoleRead(name)
+	case logical.WriteOperation:
+		return ts.handleRoleCreateUpdate(req, name)
+	case logical.DeleteOperation:
+		return ts.handleRoleDelete(name)
+	default:
+		return nil, logical.ErrUnsupportedOperation
+	}
+}
+
+// handleRoleCreateUpdate handles the create/update half of
+// auth/token/roles/name. An update loads the existing role first and
+// only overwrites the fields present in this request, so a partial
+// write (e.g. just "period") can't silently clear unrelated settings
+// such as disallowed_policies.
+func (ts *TokenStore) handleRoleCreateUpdate(req *logical.Request, name string) (*logical.Response, error) {
+	role, err := ts.tokenRole(name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		role = &TokenRole{Name: name}
+	}
+
+	if _, ok := req.Data["allowed_policies"]; ok {
+		role.AllowedPolicies = getStringSliceData(req.Data, "allowed_policies")
+	}
+	if _, ok := req.Data["disallowed_policies"]; ok {
+		role.DisallowedPolicies = getStringSliceData(req.Data, "disallowed_policies")
+	}
+	if _, ok := req.Data["path_suffix"]; ok {
+		role.PathSuffix = getStringData(req.Data, "path_suffix")
+	}
+
+	if orphanRaw, ok := req.Data["orphan"]; ok {
+		role.Orphan, _ = orphanRaw.(bool)
+	}
+
+	if periodRaw, ok := req.Data["period"]; ok {
+		dur, err := parseDurationData(periodRaw)
+		if err != nil {
+			return logical.ErrorResponse("invalid period"), logical.ErrInvalidRequest
+		}
+		role.Period = dur
+	}
+
+	if maxTTLRaw, ok := req.Data["explicit_max_ttl"]; ok {
+		dur, err := parseDurationData(maxTTLRaw)
+		if err != nil {
+			return logical.ErrorResponse("invalid explicit_max_ttl"), logical.ErrInvalidRequest
+		}
+		role.ExplicitMaxTTL = dur
+	}
+
+	raw, err := json.Marshal(role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode role: %v", err)
+	}
+
+	entry := &logical.StorageEntry{Key: tokenRolePrefix + name, Value: raw}
+	if err := ts.view.Put(entry); err != nil {
+		return nil, fmt.Errorf("failed to persist role: %v", err)
+	}
+	return nil, nil
+}
+
+// handleRoleRead handles the read half of auth/token/roles/name
+func (ts *TokenStore) handleRoleRead(name string) (*logical.Response, error) {
+	role, err := ts.tokenRole(name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"allowed_policies":    role.AllowedPolicies,
+			"disallowed_policies": role.DisallowedPolicies,
+			"orphan":              role.Orphan,
+			"period":              role.Period.String(),
+			"explicit_max_ttl":    role.ExplicitMaxTTL.String(),
+			"path_suffix":         role.PathSuffix,
+		},
+	}
+	return resp, nil
+}
+
+// handleRoleDelete handles the delete half of auth/token/roles/name
+func (ts *TokenStore) handleRoleDelete(name string) (*logical.Response, error) {
+	if err := ts.view.Delete(tokenRolePrefix + name); err != nil {
+		return nil, fmt.Errorf("failed to delete role: %v", err)
+	}
+	return nil, nil
+}
+
+// handleRoleList handles the auth/token/roles path, listing known role names
+func (ts *TokenStore) handleRoleList(req *logical.Request) (*logical.Response, error) {
+	names, err := ts.view.List(tokenRolePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan roles: %v", err)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": names,
+		},
+	}, nil
+}
+
+// handleRevokeTree handles the auth/token/revoke/id path for revocation of tokens
+// along with all of their child tokens
+func (ts *TokenStore) handleRevokeTree(req *logical.Request, id string) (*logical.Response, error) {
+	if err := ts.RevokeTree(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// handleRevokeOrphan handles the auth/token/revoke-orphan/id path for revocation
+// of tokens, but without revoking the child tokens
+func (ts *TokenStore) handleRevokeOrphan(req *logical.Request, id string) (*logical.Response, error) {
+	if err := ts.Revoke(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// handleRevokePrefix handles the auth/token/revoke-prefix/path for revocation of
+// the leases (and their associated tokens) issued under a given path.
+func (ts *TokenStore) handleRevokePrefix(req *logical.Request, prefix string) (*logical.Response, error) {
+	if err := ts.expiration.RevokePrefix(prefix); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// handleLookupAccessor handles the auth/token/lookup-accessor/accessor path for
+// querying the properties of a token via its accessor. The token ID itself
+// is never returned.
+func (ts *TokenStore) handleLookupAccessor(req *logical.Request, accessor string) (*logical.Response, error) {
+	entry, err := ts.lookupByAccessorEntry(accessor)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if entry == nil {
+		return logical.ErrorResponse("invalid accessor"), logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"accessor": entry.Accessor,
+			"policies": entry.Policies,
+			"path":     entry.Path,
+			"meta":     entry.Meta,
+			"lease":    ts.leaseDuration(entry),
+		},
+	}
+	return resp, nil
+}
+
+// leaseDuration reports the remaining lease on a token entry, via the
+// expiration manager that tracks it. It returns zero if no expiration
+// manager is wired up or the lease can't be found, e.g. for the root
+// token, which has no lease of its own.
+func (ts *TokenStore) leaseDuration(te *TokenEntry) time.Duration {
+	if ts.expiration == nil {
+		return 0
+	}
+	remaining, err := ts.expiration.TimeRemaining(te.Path, te.ID)
+	if err != nil {
+		return 0
+	}
+	return remaining
+}
+
+// handleRevokeAccessor handles the auth/token/revoke-accessor/accessor path for
+// revocation of a token tree via its accessor.
+func (ts *TokenStore) handleRevokeAccessor(req *logical.Request, accessor string) (*logical.Response, error) {
+	saltedID, err := ts.lookupByAccessor(accessor)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if err := ts.revokeTreeSalted(saltedID); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// handleListAccessors handles the auth/token/list-accessors path for listing
+// accessors of outstanding tokens. This is a root-protected endpoint.
+func (ts *TokenStore) handleListAccessors(req *logical.Request) (*logical.Response, error) {
+	root, err := ts.Lookup(req.ClientToken)
+	if err != nil || root == nil || !strContains(root.Policies, rootPolicyName) {
+		return logical.ErrorResponse("root required to list accessors"), logical.ErrInvalidRequest
+	}
+
+	// The index is keyed by the salted accessor, so the keys themselves
+	// can't be fed back into lookup-accessor/revoke-accessor (those salt
+	// their input again). Read each entry's value instead, which carries
+	// the plaintext accessor that those endpoints actually expect.
+	keys, err := ts.view.List(tokenAccessorPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan accessors: %v", err)
+	}
+
+	accessors := make([]string, 0, len(keys))
+	for _, key := range keys {
+		raw, err := ts.view.Get(tokenAccessorPrefix + key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read accessor entry: %v", err)
+		}
+		if raw == nil {
+			continue
+		}
+
+		var ae tokenAccessorEntry
+		if err := json.Unmarshal(raw.Value, &ae); err != nil {
+			return nil, fmt.Errorf("failed to decode accessor entry: %v", err)
+		}
+		accessors = append(accessors, ae.Accessor)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"keys": accessors,
+		},
+	}
+	return resp, nil
+}
+
+// handleLookup handles the auth/token/lookup/id path for querying information
+// about a particular token
+func (ts *TokenStore) handleLookup(req *logical.Request, id string) (*logical.Response, error) {
+	if id == "" {
+		id = req.ClientToken
+	}
+	if id == "" {
+		return logical.ErrorResponse("missing token ID"), logical.ErrInvalidRequest
+	}
+
+	out, err := ts.Lookup(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup: %v", err)
+	}
+	if out == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"id":          out.ID,
+			"accessor":    out.Accessor,
+			"policies":    out.Policies,
+			"path":        out.Path,
+			"meta":        out.Meta,
+			"num_uses":    out.NumUses,
+			"bound_cidrs": out.BoundCIDRs,
+			"lease":       ts.leaseDuration(out),
+		},
+	}
+	return resp, nil
+}
+
+// handleRenew handles the auth/token/renew/id path for renewal of tokens
+func (ts *TokenStore) handleRenew(req *logical.Request, id string) (*logical.Response, error) {
+	if id == "" {
+		return logical.ErrorResponse("missing token ID"), logical.ErrInvalidRequest
+	}
+
+	out, err := ts.Lookup(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup: %v", err)
+	}
+	if out == nil {
+		return logical.ErrorResponse("token not found"), logical.ErrInvalidRequest
+	}
+
+	// Periodic tokens always renew to their fixed period rather than
+	// extending the existing lease, and are exempt from any max TTL.
+	auth, err := ts.expiration.RenewToken(out.Path, out.ID, out.Period)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Auth: auth}, nil
+}
+
+// handleLookupSelf handles the auth/token/lookup-self path, charging the
+// lookup against the caller's own NumUses/BoundCIDRs, the same as any
+// other authenticated use of the token. The response describes the token
+// as it was before this use, so the last use of a use-limited token still
+// returns its details even though the token is revoked by it.
+func (ts *TokenStore) handleLookupSelf(req *logical.Request) (*logical.Response, error) {
+	resp, err := ts.handleLookup(req, req.ClientToken)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	if err := ts.useClientToken(req); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// handleRenewSelf handles the auth/token/renew-self path, letting a caller
+// renew its own token without needing root or to know its own ID.
+func (ts *TokenStore) handleRenewSelf(req *logical.Request) (*logical.Response, error) {
+	if err := ts.useClientToken(req); err != nil {
+		return nil, err
+	}
+	return ts.handleRenew(req, req.ClientToken)
+}
+
+// handleRevokeSelf handles the auth/token/revoke-self path, letting a
+// caller tear down its own token and everything issued beneath it.
+func (ts *TokenStore) handleRevokeSelf(req *logical.Request) (*logical.Response, error) {
+	if err := ts.useClientToken(req); err != nil {
+		return nil, err
+	}
+	if err := ts.RevokeTree(req.ClientToken); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// handleCapabilitiesSelf handles the auth/token/capabilities-self path,
+// reporting the capabilities the caller's own policies grant on each of
+// a list of requested paths. Root tokens are reported as having every
+// capability on every path.
+func (ts *TokenStore) handleCapabilitiesSelf(req *logical.Request) (*logical.Response, error) {
+	te, err := ts.Lookup(req.ClientToken)
+	if err != nil || te == nil {
+		return logical.ErrorResponse("invalid token"), logical.ErrInvalidRequest
+	}
+	if err := ts.UseToken(req, te); err != nil {
+		return nil, err
+	}
+
+	paths := getStringSliceData(req.Data, "paths")
+	if len(paths) == 0 {
+		return logical.ErrorResponse("paths must be specified"), logical.ErrInvalidRequest
+	}
+
+	result := make(map[string]interface{}, len(paths))
+
+	if strContains(te.Policies, rootPolicyName) {
+		for _, path := range paths {
+			result[path] = []string{"root"}
+		}
+		return &logical.Response{Data: result}, nil
+	}
+
+	acl, err := ts.policy.ACL(te.Policies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build ACL: %v", err)
+	}
+	for _, path := range paths {
+		result[path] = acl.Capabilities(path)
+	}
+	return &logical.Response{Data: result}, nil
+}
+
+// getStringSliceData extracts a string slice from a raw request data
+// value, regardless of whether it was sent as []string or []interface{}.
+func getStringSliceData(data map[string]interface{}, key string) []string {
+	raw, ok := data[key]
+	if !ok {
+		return nil
+	}
+	switch v := raw.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// getStringData extracts a single string value from raw request data.
+func getStringData(data map[string]interface{}, key string) string {
+	raw, ok := data[key]
+	if !ok {
+		return ""
+	}
+	s, _ := raw.(string)
+	return s
+}
+
+// parseDurationData parses a raw request data value as a duration. It
+// accepts the Go duration string form ("1h") as well as an already-typed
+// time.Duration, and treats an empty string as zero.
+func parseDurationData(raw interface{}) (time.Duration, error) {
+	switch v := raw.(type) {
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		return time.ParseDuration(v)
+	case time.Duration:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("invalid duration")
+	}
+}
+
+// parseIntData parses a raw request data value as an int, accepting the
+// numeric types the JSON decoder may have produced as well as a plain
+// Go int for values set directly in tests.
+func parseIntData(raw interface{}) (int, error) {
+	switch v := raw.(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("invalid integer value")
+	}
+}
+
+// getStringMapData extracts a string map from a raw request data value.
+func getStringMapData(data map[string]interface{}, key string) map[string]string {
+	raw, ok := data[key]
+	if !ok {
+		return nil
+	}
+	switch v := raw.(type) {
+	case map[string]string:
+		return v
+	case map[string]interface{}:
+		out := make(map[string]string, len(v))
+		for k, item := range v {
+			if s, ok := item.(string); ok {
+				out[k] = s
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// strContains checks if a string is present in a slice of strings
+func strContains(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// strListSubset checks if a given list is a subset of another set
+func strListSubset(super, sub []string) bool {
+	for _, item := range sub {
+		if !strContains(super, item) {
+			return false
+		}
+	}
+	return true
+}
+
+// strListOverlap checks if any element of sub is present in list
+func strListOverlap(list, sub []string) bool {
+	for _, item := range sub {
+		if strContains(list, item) {
+			return true
+		}
+	}
+	return false
+}
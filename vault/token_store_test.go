@@ -528,14 +528,21 @@ func TestTokenStore_HandleRequest_Lookup(t *testing.T) {
 	}
 
 	exp := map[string]interface{}{
-		"id":       root,
-		"policies": []string{"root"},
-		"path":     "sys/root",
-		"meta":     map[string]string(nil),
+		"id":          root,
+		"accessor":    resp.Data["accessor"],
+		"policies":    []string{"root"},
+		"path":        "sys/root",
+		"meta":        map[string]string(nil),
+		"num_uses":    0,
+		"bound_cidrs": []string(nil),
+		"lease":       time.Duration(0),
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("bad: %#v exp: %#v", resp.Data, exp)
 	}
+	if resp.Data["accessor"] == "" {
+		t.Fatalf("missing accessor")
+	}
 }
 
 func TestTokenStore_HandleRequest_RevokePrefix(t *testing.T) {
@@ -589,14 +596,21 @@ func TestTokenStore_HandleRequest_LookupSelf(t *testing.T) {
 	}
 
 	exp := map[string]interface{}{
-		"id":       root,
-		"policies": []string{"root"},
-		"path":     "sys/root",
-		"meta":     map[string]string(nil),
+		"id":          root,
+		"accessor":    resp.Data["accessor"],
+		"policies":    []string{"root"},
+		"path":        "sys/root",
+		"meta":        map[string]string(nil),
+		"num_uses":    0,
+		"bound_cidrs": []string(nil),
+		"lease":       time.Duration(0),
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("bad: %#v exp: %#v", resp.Data, exp)
 	}
+	if resp.Data["accessor"] == "" {
+		t.Fatalf("missing accessor")
+	}
 }
 
 func TestTokenStore_HandleRequest_Renew(t *testing.T) {
@@ -629,6 +643,713 @@ func TestTokenStore_HandleRequest_Renew(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_Renew_Period(t *testing.T) {
+	exp := mockExpiration(t)
+	ts := exp.tokenStore
+
+	root, err := ts.RootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	roleReq := logical.TestRequest(t, logical.WriteOperation, "roles/periodic")
+	roleReq.Data["period"] = "10s"
+	if _, err := ts.HandleRequest(roleReq); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	createReq := logical.TestRequest(t, logical.WriteOperation, "create/periodic")
+	createReq.ClientToken = root.ID
+	createReq.Data["policies"] = []string{"foo"}
+
+	createResp, err := ts.HandleRequest(createReq)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, createResp)
+	}
+	childID := createResp.Auth.ClientToken
+
+	child, err := ts.Lookup(childID)
+	if err != nil || child == nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Register a lease far longer than the period, so a renewal that
+	// merely extended the existing lease (ignoring Period) would be
+	// distinguishable from one that correctly resets to it.
+	auth := &logical.Auth{
+		ClientToken: childID,
+		Lease:       time.Hour,
+	}
+	if err := exp.RegisterAuth(child.Path, auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	renewReq := logical.TestRequest(t, logical.WriteOperation, "renew/"+childID)
+	resp, err := ts.HandleRequest(renewReq)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Auth.Lease != 10*time.Second {
+		t.Fatalf("bad: period not honored, got lease %v", resp.Auth.Lease)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Accessor(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["policies"] = []string{"foo"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Auth.Accessor == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// A second token should never share an accessor with the first
+	resp2, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp2)
+	}
+	if resp2.Auth.Accessor == resp.Auth.Accessor {
+		t.Fatalf("bad: accessors collided: %#v", resp2)
+	}
+}
+
+func TestTokenStore_HandleRequest_LookupAccessor(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev", "ops"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-accessor/"+ent.Accessor)
+	req.ClientToken = root
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["id"] != nil {
+		t.Fatalf("accessor lookup leaked the token ID: %#v", resp.Data)
+	}
+	if !reflect.DeepEqual(resp.Data["policies"], ent.Policies) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestTokenStore_HandleRequest_RevokeAccessor(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev", "ops"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "revoke-accessor/"+ent.Accessor)
+	req.ClientToken = root
+	if _, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err := ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The accessor index entry should be gone too
+	if _, err := ts.lookupByAccessor(ent.Accessor); err == nil {
+		t.Fatalf("expected accessor to be removed")
+	}
+}
+
+func TestTokenStore_Revoke_RemovesAccessor(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev", "ops"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ts.Revoke(ent.ID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := ts.lookupByAccessor(ent.Accessor); err == nil {
+		t.Fatalf("expected accessor to be removed")
+	}
+}
+
+func TestTokenStore_RevokeTree_RemovesAccessor(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent1 := &TokenEntry{}
+	if err := ts.Create(ent1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ent2 := &TokenEntry{Parent: ent1.ID}
+	if err := ts.Create(ent2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ts.RevokeTree(ent1.ID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := ts.lookupByAccessor(ent1.Accessor); err == nil {
+		t.Fatalf("expected accessor to be removed")
+	}
+	if _, err := ts.lookupByAccessor(ent2.Accessor); err == nil {
+		t.Fatalf("expected accessor to be removed")
+	}
+}
+
+func TestTokenStore_HandleRequest_LookupAccessor_NotAToken(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev", "ops"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The accessor itself must never work as a client token
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup/"+ent.Accessor)
+	req.ClientToken = root
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp != nil {
+		t.Fatalf("bad: accessor resolved as a token: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_ListAccessors(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	req := logical.TestRequest(t, logical.ReadOperation, "list-accessors")
+	req.ClientToken = root
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	keys, ok := resp.Data["keys"].([]string)
+	if !ok || len(keys) == 0 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Every listed handle must work as-is against lookup-accessor and
+	// revoke-accessor, not just come back non-empty.
+	for _, accessor := range keys {
+		lookupReq := logical.TestRequest(t, logical.ReadOperation, "lookup-accessor/"+accessor)
+		lookupReq.ClientToken = root
+		lookupResp, err := ts.HandleRequest(lookupReq)
+		if err != nil {
+			t.Fatalf("err: %v %v", err, lookupResp)
+		}
+		if lookupResp == nil || lookupResp.Data["accessor"] != accessor {
+			t.Fatalf("listed accessor %q did not resolve via lookup-accessor: %#v", accessor, lookupResp)
+		}
+	}
+}
+
+func TestTokenStore_HandleRequest_ListAccessors_NonRoot(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	req := logical.TestRequest(t, logical.ReadOperation, "list-accessors")
+	req.ClientToken = "client"
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != "root required to list accessors" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_RoleCRUD(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "roles/test")
+	req.Data["allowed_policies"] = []string{"foo", "bar"}
+	req.Data["period"] = "72h"
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "roles/test")
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["allowed_policies"], []string{"foo", "bar"}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp.Data["period"] != (72 * time.Hour).String() {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	req = logical.TestRequest(t, logical.DeleteOperation, "roles/test")
+	if _, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "roles/test")
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_RoleCreateUpdate_PartialUpdatePreservesExisting(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "roles/test")
+	req.Data["allowed_policies"] = []string{"foo", "bar"}
+	req.Data["disallowed_policies"] = []string{"restricted"}
+	req.Data["orphan"] = true
+	if _, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A follow-up write that only touches "period" should not wipe out
+	// the fields set above.
+	req = logical.TestRequest(t, logical.WriteOperation, "roles/test")
+	req.Data["period"] = "72h"
+	if _, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "roles/test")
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["allowed_policies"], []string{"foo", "bar"}) {
+		t.Fatalf("bad: allowed_policies wiped by partial update: %#v", resp.Data)
+	}
+	if !reflect.DeepEqual(resp.Data["disallowed_policies"], []string{"restricted"}) {
+		t.Fatalf("bad: disallowed_policies wiped by partial update: %#v", resp.Data)
+	}
+	if resp.Data["orphan"] != true {
+		t.Fatalf("bad: orphan wiped by partial update: %#v", resp.Data)
+	}
+	if resp.Data["period"] != (72 * time.Hour).String() {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Role_AllowedSuperset(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	roleReq := logical.TestRequest(t, logical.WriteOperation, "roles/super")
+	roleReq.Data["allowed_policies"] = []string{"foo", "bar"}
+	if _, err := ts.HandleRequest(roleReq); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create/super")
+	req.ClientToken = "client"
+	req.Data["policies"] = []string{"foo", "bar"}
+
+	// Plain create would reject this, since "bar" is not a subset of the
+	// caller's own policies
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Auth.ClientToken == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Role_DisallowedPolicy(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	roleReq := logical.TestRequest(t, logical.WriteOperation, "roles/norestricted")
+	roleReq.Data["disallowed_policies"] = []string{"restricted"}
+	if _, err := ts.HandleRequest(roleReq); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create/norestricted")
+	req.ClientToken = root
+	req.Data["policies"] = []string{"restricted"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != "token policies must not contain any of the role's disallowed policies" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Role_NoAllowedPolicies_NonRoot(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	roleReq := logical.TestRequest(t, logical.WriteOperation, "roles/norestricted")
+	roleReq.Data["disallowed_policies"] = []string{"restricted"}
+	if _, err := ts.HandleRequest(roleReq); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A role with no allowed_policies grants no escalation of its own,
+	// so a non-root caller is still bound to a subset of its own policies.
+	req := logical.TestRequest(t, logical.WriteOperation, "create/norestricted")
+	req.ClientToken = "client"
+	req.Data["policies"] = []string{"bar"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != "child policies must be subset of parent" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// And it must never be able to mint a root token through the role
+	req = logical.TestRequest(t, logical.WriteOperation, "create/norestricted")
+	req.ClientToken = "client"
+	req.Data["policies"] = []string{"root"}
+
+	resp, err = ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != "root policy cannot be granted by a non-root token" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Role_Orphan(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	roleReq := logical.TestRequest(t, logical.WriteOperation, "roles/orphaning")
+	roleReq.Data["orphan"] = true
+	roleReq.Data["allowed_policies"] = []string{"foo"}
+	if _, err := ts.HandleRequest(roleReq); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create/orphaning")
+	req.ClientToken = root
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	out, err := ts.Lookup(resp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.Parent != "" {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out.Role != "orphaning" {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_UnknownRole(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create/does-not-exist")
+	req.ClientToken = root
+	req.Data["policies"] = []string{"foo"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != `unknown role "does-not-exist"` {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_UseToken_NumUses(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}, NumUses: 2}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-self")
+	if err := ts.UseToken(req, ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ent.NumUses != 1 {
+		t.Fatalf("bad: %#v", ent)
+	}
+
+	out, err := ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.NumUses != 1 {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The last use should revoke the token
+	if err := ts.UseToken(req, ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err = ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: token should be revoked: %#v", out)
+	}
+}
+
+func TestTokenStore_UseToken_Unlimited(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-self")
+	for i := 0; i < 5; i++ {
+		if err := ts.UseToken(req, ent); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	out, err := ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil {
+		t.Fatalf("token with no use limit should not be revoked")
+	}
+}
+
+func TestTokenStore_UseToken_BoundCIDRs(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{
+		Path:       "test",
+		Policies:   []string{"dev"},
+		BoundCIDRs: []string{"127.0.0.1/32"},
+	}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-self")
+	req.Connection = &logical.Connection{RemoteAddr: "10.0.0.5"}
+	if err := ts.UseToken(req, ent); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	req.Connection.RemoteAddr = "127.0.0.1"
+	if err := ts.UseToken(req, ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_NumUsesAndBoundCIDRs(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["policies"] = []string{"foo"}
+	req.Data["num_uses"] = 1
+	req.Data["bound_cidrs"] = []string{"127.0.0.1/32"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	out, err := ts.Lookup(resp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.NumUses != 1 {
+		t.Fatalf("bad: %#v", out)
+	}
+	if !reflect.DeepEqual(out.BoundCIDRs, []string{"127.0.0.1/32"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_HandleRequest_LookupSelf_ConsumesNumUses(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}, NumUses: 1}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-self")
+	req.ClientToken = ent.ID
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp == nil {
+		t.Fatalf("bad: expected a response for the token's last use")
+	}
+
+	// The single use was just consumed, which should have revoked the
+	// token - a second lookup-self must come back empty.
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp != nil {
+		t.Fatalf("bad: token should have been revoked after its one use: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_LookupSelf_BoundCIDRs(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{
+		Path:       "test",
+		Policies:   []string{"dev"},
+		BoundCIDRs: []string{"127.0.0.1/32"},
+	}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-self")
+	req.ClientToken = ent.ID
+	req.Connection = &logical.Connection{RemoteAddr: "10.0.0.5"}
+
+	if _, err := ts.HandleRequest(req); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	req.Connection.RemoteAddr = "127.0.0.1"
+	if _, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestTokenStore_HandleRequest_RenewSelf(t *testing.T) {
+	exp := mockExpiration(t)
+	ts := exp.tokenStore
+
+	root, err := ts.RootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	auth := &logical.Auth{
+		ClientToken: root.ID,
+		Lease:       time.Hour,
+	}
+	if err := exp.RegisterAuth("sys/root", auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "renew-self")
+	req.ClientToken = root.ID
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Auth, auth) {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_RevokeSelf(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "child", []string{"foo"})
+	// Only root may pick a token ID through create, so the non-root
+	// child's sub-token is stored directly.
+	subChild := &TokenEntry{ID: "sub-child", Parent: "child", Policies: []string{"foo"}}
+	if err := ts.Create(subChild); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "revoke-self")
+	req.ClientToken = "child"
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	out, err := ts.Lookup("child")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// A revoke-self tears down the whole tree below the caller too
+	out, err = ts.Lookup("sub-child")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestTokenStore_HandleRequest_CapabilitiesSelf_Root(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "capabilities-self")
+	req.ClientToken = root
+	req.Data["paths"] = []string{"secret/foo", "sys/mounts"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	for _, path := range []string{"secret/foo", "sys/mounts"} {
+		if !reflect.DeepEqual(resp.Data[path], []string{"root"}) {
+			t.Fatalf("bad: %#v", resp.Data)
+		}
+	}
+}
+
+func TestTokenStore_HandleRequest_CapabilitiesSelf_NonRoot(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	req := logical.TestRequest(t, logical.WriteOperation, "capabilities-self")
+	req.ClientToken = "client"
+	req.Data["paths"] = []string{"secret/foo"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if reflect.DeepEqual(resp.Data["secret/foo"], []string{"root"}) {
+		t.Fatalf("non-root token should not be granted root capabilities: %#v", resp.Data)
+	}
+}
+
 func testMakeToken(t *testing.T, ts *TokenStore, root, client string, policy []string) {
 	req := logical.TestRequest(t, logical.WriteOperation, "create")
 	req.ClientToken = root